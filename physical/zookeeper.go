@@ -1,8 +1,20 @@
 package physical
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,8 +27,44 @@ import (
 // prefix within Zookeeper. It is used in production situations as
 // it allows Vault to run on multiple machines in a highly-available manner.
 type ZookeeperBackend struct {
-	path   string
+	// dataPath holds Put/Get/Delete/List entries, under dataACL (the "acl"
+	// option, world ACL by default), which can be restricted so that only
+	// the Vault service principal can read or write.
+	dataPath string
+	dataACL  []zk.ACL
+
+	// locksPath holds HA lock nodes, under locksACL (the "locks_acl"
+	// option, world ACL by default), which can be looser than dataACL so
+	// that monitoring tools can enumerate standbys and the current leader
+	// value without being granted read access to dataPath's secrets.
+	locksPath string
+	locksACL  []zk.ACL
+
 	client *zk.Conn
+
+	// existsCache tracks path prefixes already known to exist, so
+	// ensurePath doesn't re-issue an Exists RPC for every ancestor of
+	// every write under the same directory.
+	existsCache sync.Map
+
+	// chunkThreshold is the largest entry value, in bytes, written as a
+	// single znode. Larger values are split across chunk znodes to stay
+	// under ZooKeeper's jute.maxbuffer limit.
+	chunkThreshold int
+}
+
+// defaultChunkThreshold is comfortably under ZooKeeper's default 1 MiB
+// jute.maxbuffer, leaving room for the rest of the znode's framing.
+const defaultChunkThreshold = 900 * 1024
+
+// zkACLPerms maps the single-letter permission names accepted in the
+// "acl" config option to their zk.Perm bit.
+var zkACLPerms = map[byte]int32{
+	'r': zk.PermRead,
+	'w': zk.PermWrite,
+	'c': zk.PermCreate,
+	'd': zk.PermDelete,
+	'a': zk.PermAdmin,
 }
 
 // newZookeeperBackend constructs a Zookeeper backend using the given API client
@@ -36,6 +84,19 @@ func newZookeeperBackend(conf map[string]string) (Backend, error) {
 		path = "/" + path
 	}
 
+	// Data and locks live in separate namespaces under path so that each
+	// can have its own ACL: data holds encrypted secrets and warrants a
+	// restrictive ACL, while locks only need to be enumerable by
+	// monitoring tools.
+	dataPath := path + "data/"
+	if raw, ok := conf["data_path"]; ok {
+		dataPath = raw
+	}
+	locksPath := path + "locks/"
+	if raw, ok := conf["locks_path"]; ok {
+		locksPath = raw
+	}
+
 	// Configure the client, default to localhost instance
 	var machines string
 	machines, ok = conf["address"]
@@ -43,48 +104,388 @@ func newZookeeperBackend(conf map[string]string) (Backend, error) {
 		machines = "localhost:2181"
 	}
 
+	// Allow operators to override how long we wait to establish a session
+	connectTimeout := time.Second
+	if raw, ok := conf["connection_timeout"]; ok {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connection_timeout: %v", err)
+		}
+		connectTimeout = time.Duration(seconds) * time.Second
+	}
+
+	// Allow operators to tune how large a value can get before it's split
+	// across chunk znodes.
+	chunkThreshold := defaultChunkThreshold
+	if raw, ok := conf["chunk_threshold"]; ok {
+		threshold, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk_threshold: %v", err)
+		}
+		chunkThreshold = threshold
+	}
+
+	// Parse the ACLs that will be applied to the data and locks
+	// namespaces. Both default to the world-readable/writable ACL used
+	// historically by this backend.
+	dataACL := zk.WorldACL(zk.PermAll)
+	if rawACL, ok := conf["acl"]; ok {
+		parsed, err := parseZKACL(rawACL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse acl: %v", err)
+		}
+		dataACL = parsed
+	}
+	locksACL := zk.WorldACL(zk.PermAll)
+	if rawACL, ok := conf["locks_acl"]; ok {
+		parsed, err := parseZKACL(rawACL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse locks_acl: %v", err)
+		}
+		locksACL = parsed
+	}
+
+	// Parse the digest credential, if any, that should be added to the
+	// session once connected.
+	var authScheme, authID string
+	if rawAuth, ok := conf["auth"]; ok {
+		scheme, id, err := parseZKAuth(rawAuth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse auth: %v", err)
+		}
+		authScheme, authID = scheme, id
+
+		// Make sure the configured principal is actually granted
+		// permissions on both the data and locks ACLs, or the operator
+		// would lock themselves out of their own secrets or HA locks as
+		// soon as the world ACLs above are overridden.
+		if !aclGrantsDigest(dataACL, authID) {
+			return nil, fmt.Errorf("auth principal %q is not present in the configured acl", strings.SplitN(authID, ":", 2)[0])
+		}
+		if !aclGrantsDigest(locksACL, authID) {
+			return nil, fmt.Errorf("auth principal %q is not present in the configured locks_acl", strings.SplitN(authID, ":", 2)[0])
+		}
+	}
+
+	tlsConfig, err := zookeeperTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
 	// Attempt to create the ZK client
-	client, _, err := zk.Connect(strings.Split(machines, ","), time.Second)
+	var client *zk.Conn
+	var events <-chan zk.Event
+	if tlsConfig != nil {
+		dialer := func(network, address string, timeout time.Duration) (net.Conn, error) {
+			return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, address, tlsConfig)
+		}
+		client, events, err = zk.Connect(strings.Split(machines, ","), connectTimeout, zk.WithDialer(dialer))
+	} else {
+		client, events, err = zk.Connect(strings.Split(machines, ","), connectTimeout)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("client setup failed: %v", err)
 	}
 
+	if authScheme != "" {
+		if err := client.AddAuth(authScheme, []byte(authID)); err != nil {
+			return nil, fmt.Errorf("failed to add auth: %v", err)
+		}
+	}
+
 	// Setup the backend
 	c := &ZookeeperBackend{
-		path:   path,
-		client: client,
+		dataPath:       dataPath,
+		dataACL:        dataACL,
+		locksPath:      locksPath,
+		locksACL:       locksACL,
+		client:         client,
+		chunkThreshold: chunkThreshold,
 	}
+	go c.watchSession(events)
+
+	// One-time migration from the flat layout this backend used prior to
+	// the dataPath/locksPath split, where both data and lock znodes lived
+	// directly under path.
+	if conf["migrate"] == "true" {
+		if err := c.migrateLegacyLayout(path); err != nil {
+			return nil, fmt.Errorf("failed to migrate legacy zookeeper layout: %v", err)
+		}
+	}
+
 	return c, nil
 }
 
+// migrateLegacyLayout walks legacyPath — the single flat prefix this
+// backend used before data and locks were split into their own namespaces
+// — and moves every persisted entry it finds into the new dataPath
+// namespace via the ordinary Put path, so chunking and ACLs are applied
+// exactly as they would be for a fresh write, then removes it from the
+// legacy location so the secret isn't left behind under the old, looser
+// ACL. HA lock nodes need no migration: they're ephemeral and are
+// recreated under locksPath the next time a lock is acquired.
+func (c *ZookeeperBackend) migrateLegacyLayout(legacyPath string) error {
+	legacyPath = strings.TrimSuffix(legacyPath, "/")
+	if legacyPath == "" {
+		return nil
+	}
+
+	children, _, err := c.client.Children(legacyPath)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list legacy path %q: %v", legacyPath, err)
+	}
+
+	newDataPath := strings.TrimSuffix(c.dataPath, "/")
+	newLocksPath := strings.TrimSuffix(c.locksPath, "/")
+	for _, child := range children {
+		fullPath := legacyPath + "/" + child
+		// Don't migrate the namespaces we just created (e.g. on a
+		// restart after a previous migration already ran).
+		if fullPath == newDataPath || fullPath == newLocksPath {
+			continue
+		}
+		if err := c.migrateLegacyKey(legacyPath, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLegacyKey moves the legacy znode at legacyPath+"/"+key, and
+// everything beneath it, into the new dataPath namespace at the same
+// relative key, then deletes the legacy copy.
+func (c *ZookeeperBackend) migrateLegacyKey(legacyPath, key string) error {
+	fullPath := legacyPath + "/" + key
+
+	// readValue reassembles a chunked legacy value from its chunk
+	// children, rather than migrating the chunk header's raw bytes as if
+	// they were the entry's real value.
+	value, _, err := c.readValue(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read legacy key %q: %v", fullPath, err)
+	}
+	if value != nil {
+		if err := c.Put(&Entry{Key: key, Value: value}); err != nil {
+			return fmt.Errorf("failed to migrate legacy key %q: %v", fullPath, err)
+		}
+	}
+
+	children, _, err := c.client.Children(fullPath)
+	if err != nil && err != zk.ErrNoNode {
+		return fmt.Errorf("failed to list legacy key %q: %v", fullPath, err)
+	}
+	for _, child := range children {
+		if strings.HasPrefix(child, chunkChildPrefix) {
+			// Already migrated above as part of reassembling the
+			// chunked value at fullPath; removed below along with the
+			// rest of fullPath's subtree.
+			continue
+		}
+		if err := c.migrateLegacyKey(legacyPath, key+"/"+child); err != nil {
+			return err
+		}
+	}
+
+	// Everything under fullPath has been copied to its new home; remove
+	// the legacy copy, including any now-redundant chunk children.
+	if err := c.deletePath(fullPath); err != nil && err != zk.ErrNoNode {
+		return fmt.Errorf("failed to remove migrated legacy key %q: %v", fullPath, err)
+	}
+	return nil
+}
+
+// zookeeperTLSConfig builds a *tls.Config from the tls_cert_file,
+// tls_key_file, and tls_ca_file config options. It returns a nil config
+// (and nil error) when none of the TLS options are set, so the caller
+// falls back to a plaintext connection.
+func zookeeperTLSConfig(conf map[string]string) (*tls.Config, error) {
+	certFile := conf["tls_cert_file"]
+	keyFile := conf["tls_key_file"]
+	caFile := conf["tls_ca_file"]
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls_cert_file/tls_key_file: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		caBytes, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca_file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("failed to parse tls_ca_file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// parseZKACL parses the "acl" config option into a list of zk.ACL entries.
+// It accepts either a JSON array of {"scheme","id","perms"} objects, or a
+// comma-separated list of "scheme:id:perms" triples where perms is any
+// combination of the letters r(ead), w(rite), c(reate), d(elete), a(dmin).
+func parseZKACL(raw string) ([]zk.ACL, error) {
+	trimmed := strings.TrimSpace(raw)
+	if strings.HasPrefix(trimmed, "[") {
+		var entries []zk.ACL
+		if err := json.Unmarshal([]byte(trimmed), &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+
+	var acl []zk.ACL
+	for _, part := range strings.Split(trimmed, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.SplitN(part, ":", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid acl entry %q, expected scheme:id:perms", part)
+		}
+
+		var perms int32
+		for i := 0; i < len(fields[2]); i++ {
+			bit, ok := zkACLPerms[fields[2][i]]
+			if !ok {
+				return nil, fmt.Errorf("invalid acl entry %q, unknown perm %q", part, string(fields[2][i]))
+			}
+			perms |= bit
+		}
+
+		acl = append(acl, zk.ACL{Scheme: fields[0], ID: fields[1], Perms: perms})
+	}
+	return acl, nil
+}
+
+// parseZKAuth parses the "auth" config option, formatted as
+// "scheme:user:password", into the scheme and the auth blob expected by
+// zk.Conn.AddAuth. Only the "digest" scheme is currently supported.
+func parseZKAuth(raw string) (scheme, auth string, err error) {
+	fields := strings.SplitN(raw, ":", 2)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("invalid auth %q, expected scheme:credential", raw)
+	}
+	scheme = fields[0]
+	if scheme != "digest" {
+		return "", "", fmt.Errorf("unsupported auth scheme %q", scheme)
+	}
+	if !strings.Contains(fields[1], ":") {
+		return "", "", fmt.Errorf("invalid digest auth %q, expected user:password", fields[1])
+	}
+	return scheme, fields[1], nil
+}
+
+// aclGrantsDigest reports whether the acl contains an entry that grants
+// permissions to the given "digest" scheme auth (formatted "user:password").
+func aclGrantsDigest(acl []zk.ACL, digestAuth string) bool {
+	fields := strings.SplitN(digestAuth, ":", 2)
+	if len(fields) != 2 {
+		return false
+	}
+	user, password := fields[0], fields[1]
+
+	hash := sha1.Sum([]byte(user + ":" + password))
+	id := user + ":" + base64.StdEncoding.EncodeToString(hash[:])
+
+	for _, entry := range acl {
+		if entry.Scheme == "digest" && entry.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 // ensurePath is used to create each node in the path hierarchy.
 // We avoid calling this optimistically, and invoke it when we get
-// an error during an operation
-func (c *ZookeeperBackend) ensurePath(path string, value []byte) error {
+// an error during an operation.
+//
+// Ancestors already known to exist are tracked in existsCache so that
+// repeated writes under the same directory (Vault's typical
+// sys/token/id/<hash> layout) don't re-issue an Exists RPC for every
+// ancestor on every call. Any ancestors still missing are created in a
+// single batched multi-op request when the connected server supports it,
+// falling back to one Create per node otherwise.
+func (c *ZookeeperBackend) ensurePath(path string, value []byte, acl []zk.ACL) error {
 	nodes := strings.Split(path, "/")
-	acl := zk.WorldACL(zk.PermAll)
+
+	var missing []string
 	fullPath := ""
 	for index, node := range nodes {
-		if strings.TrimSpace(node) != "" {
-			fullPath += "/" + node
-			isLastNode := index+1 == len(nodes)
-
-			// set parent nodes to nil, leaf to value
-			// this block reduces round trips by being smart on the leaf create/set
-			if exists, _, _ := c.client.Exists(fullPath); !isLastNode && !exists {
-				if _, err := c.client.Create(fullPath, nil, int32(0), acl); err != nil {
-					return err
-				}
-			} else if isLastNode && !exists {
-				if _, err := c.client.Create(fullPath, value, int32(0), acl); err != nil {
-					return err
-				}
-			} else if isLastNode && exists {
-				if _, err := c.client.Set(fullPath, value, int32(-1)); err != nil {
-					return err
-				}
+		if strings.TrimSpace(node) == "" {
+			continue
+		}
+		fullPath += "/" + node
+		if index+1 == len(nodes) {
+			// Leaf node; handled separately below once its ancestors exist.
+			break
+		}
+
+		if _, cached := c.existsCache.Load(fullPath); cached {
+			continue
+		}
+		if exists, _, _ := c.client.Exists(fullPath); exists {
+			c.existsCache.Store(fullPath, true)
+			continue
+		}
+		missing = append(missing, fullPath)
+	}
+
+	if len(missing) > 0 {
+		if err := c.createMissing(missing, acl); err != nil {
+			return err
+		}
+	}
+
+	if exists, _, _ := c.client.Exists(path); !exists {
+		if _, err := c.client.Create(path, value, int32(0), acl); err != nil {
+			return err
+		}
+	} else if _, err := c.client.Set(path, value, int32(-1)); err != nil {
+		return err
+	}
+	c.existsCache.Store(path, true)
+	return nil
+}
+
+// createMissing creates each of the given ancestor paths as empty znodes.
+// It first attempts a single batched multi-op request; if the connected
+// server doesn't support multi (older ZK versions don't), it falls back to
+// issuing one Create per path, the way ensurePath always did historically.
+func (c *ZookeeperBackend) createMissing(missing []string, acl []zk.ACL) error {
+	ops := make([]interface{}, len(missing))
+	for i, p := range missing {
+		ops[i] = &zk.CreateRequest{Path: p, Data: nil, Acl: acl, Flags: 0}
+	}
+
+	if _, err := c.client.Multi(ops...); err == nil {
+		for _, p := range missing {
+			c.existsCache.Store(p, true)
+		}
+		return nil
+	}
+
+	for _, p := range missing {
+		if exists, _, _ := c.client.Exists(p); !exists {
+			if _, err := c.client.Create(p, nil, int32(0), acl); err != nil && err != zk.ErrNodeExists {
+				return err
 			}
 		}
+		c.existsCache.Store(p, true)
 	}
 	return nil
 }
@@ -106,49 +507,356 @@ func (c *ZookeeperBackend) deletePath(path string) error {
 		return err
 	}
 
+	c.existsCache.Delete(path)
 	return nil
 }
 
+// watchSession invalidates the ancestor-exists cache used by ensurePath
+// whenever the client's session is lost. Any path cached as existing may
+// have been created by this session and lost along with it, so it's not
+// safe to keep trusting the cache across a session expiration.
+func (c *ZookeeperBackend) watchSession(events <-chan zk.Event) {
+	for event := range events {
+		if event.State == zk.StateExpired {
+			c.existsCache.Range(func(key, _ interface{}) bool {
+				c.existsCache.Delete(key)
+				return true
+			})
+		}
+	}
+}
+
 // Put is used to insert or update an entry
 func (c *ZookeeperBackend) Put(entry *Entry) error {
 	defer metrics.MeasureSince([]string{"zookeeper", "put"}, time.Now())
 
+	fullPath := c.dataPath + entry.Key
+	if len(entry.Value) > c.chunkThreshold {
+		return c.putChunked(fullPath, entry.Value)
+	}
+
 	// Attempt to set the full path
-	fullPath := c.path + entry.Key
 	_, err := c.client.Set(fullPath, entry.Value, -1)
 
 	// If we get ErrNoNode, we need to construct the path hierarchy
 	if err == zk.ErrNoNode {
-		return c.ensurePath(fullPath, entry.Value)
+		if err := c.ensurePath(fullPath, entry.Value, c.dataACL); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
 	}
-	return err
+
+	// A previous, larger write at this key may have left chunk children
+	// behind now that the value fits in a single znode again.
+	return c.deleteStaleChunks(fullPath, 0)
+}
+
+// zkChunkHeader is stored at the entry's own path in place of its value
+// when that value was too large for a single znode and was split across
+// sibling chunk znodes instead.
+type zkChunkHeader struct {
+	Version     int    `json:"version"`
+	TotalChunks int    `json:"total_chunks"`
+	SHA256      string `json:"sha256"`
+}
+
+const zkChunkHeaderVersion = 1
+
+// chunkChildPrefix names the sibling znodes, under an entry's own path,
+// that hold its value once chunked. List filters these out so the chunk
+// layout stays invisible to callers.
+const chunkChildPrefix = "__chunk_"
+
+// putChunked splits value across sibling "<fullPath>/__chunk_NNNN" znodes,
+// each no larger than c.chunkThreshold, and replaces fullPath's own value
+// with a zkChunkHeader describing how to reassemble and verify them. This
+// works around ZooKeeper's jute.maxbuffer limit (1 MiB by default) on a
+// single znode's payload.
+func (c *ZookeeperBackend) putChunked(fullPath string, value []byte) error {
+	total := chunkCount(len(value), c.chunkThreshold)
+	if err := c.writeChunks(fullPath, value, total); err != nil {
+		return err
+	}
+
+	// Remove any leftover chunks from a previous, larger write at this path.
+	if err := c.deleteStaleChunks(fullPath, total); err != nil {
+		return err
+	}
+
+	header, err := buildChunkHeader(value, total)
+	if err != nil {
+		return err
+	}
+	return c.ensurePath(fullPath, header, c.dataACL)
+}
+
+// checkAndSetChunked is CheckAndSet's counterpart to putChunked. Unlike
+// putChunked, it can't write the chunk children before the header: a losing
+// CAS attempt must leave the existing entry completely untouched, not
+// overwrite its chunk data with the rejected value while its (unchanged)
+// header still describes the old one. So the header's version-checked write
+// and every chunk write are staged into a single zk.Multi transaction that
+// commits or fails as a unit.
+func (c *ZookeeperBackend) checkAndSetChunked(fullPath string, value []byte, previousVersion int32) error {
+	total := chunkCount(len(value), c.chunkThreshold)
+	header, err := buildChunkHeader(value, total)
+	if err != nil {
+		return err
+	}
+
+	headerExists, _, _ := c.client.Exists(fullPath)
+	if !headerExists && previousVersion != 0 {
+		return ErrBadVersion
+	}
+
+	ops := checkAndSetChunkedOps(fullPath, value, c.chunkThreshold, previousVersion, c.dataACL, header, headerExists, func(idx int) bool {
+		exists, _, _ := c.client.Exists(chunkPath(fullPath, idx))
+		return exists
+	})
+
+	if _, err := c.client.Multi(ops...); err != nil {
+		switch err {
+		case zk.ErrBadVersion, zk.ErrNodeExists:
+			return ErrBadVersion
+		default:
+			return err
+		}
+	}
+
+	return c.deleteStaleChunks(fullPath, total)
+}
+
+// checkAndSetChunkedOps builds the zk.Multi operations checkAndSetChunked
+// needs to atomically write value's chunk children together with a header
+// describing them. headerExists and chunkExists report whether a znode
+// already occupies the header/chunk path, so each gets a Create or a SetData
+// op accordingly. Only the header's SetData is version-checked (against
+// previousVersion); chunk SetData ops use version -1 and rely on the header
+// check to fail the whole multi.
+func checkAndSetChunkedOps(fullPath string, value []byte, chunkThreshold int, previousVersion int32, acl []zk.ACL, header []byte, headerExists bool, chunkExists func(idx int) bool) []interface{} {
+	total := chunkCount(len(value), chunkThreshold)
+	ops := make([]interface{}, 0, total+1)
+
+	if headerExists {
+		ops = append(ops, &zk.SetDataRequest{Path: fullPath, Data: header, Version: previousVersion})
+	} else {
+		ops = append(ops, &zk.CreateRequest{Path: fullPath, Data: header, Acl: acl, Flags: 0})
+	}
+
+	for idx := 0; idx < total; idx++ {
+		start, end := chunkBounds(idx, len(value), chunkThreshold)
+		path := chunkPath(fullPath, idx)
+		if chunkExists(idx) {
+			ops = append(ops, &zk.SetDataRequest{Path: path, Data: value[start:end], Version: -1})
+		} else {
+			ops = append(ops, &zk.CreateRequest{Path: path, Data: value[start:end], Acl: acl, Flags: 0})
+		}
+	}
+	return ops
+}
+
+// writeChunks writes value across sibling "<fullPath>/__chunk_NNNN" znodes
+// of at most c.chunkThreshold bytes each.
+func (c *ZookeeperBackend) writeChunks(fullPath string, value []byte, total int) error {
+	for idx := 0; idx < total; idx++ {
+		start, end := chunkBounds(idx, len(value), c.chunkThreshold)
+		if err := c.ensurePath(chunkPath(fullPath, idx), value[start:end], c.dataACL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkCount returns the number of chunkThreshold-sized pieces needed to
+// store a value of the given length.
+func chunkCount(length, chunkThreshold int) int {
+	return (length + chunkThreshold - 1) / chunkThreshold
+}
+
+// chunkBounds returns the [start, end) byte range of chunk idx within a
+// value of the given length.
+func chunkBounds(idx, length, chunkThreshold int) (start, end int) {
+	start = idx * chunkThreshold
+	end = start + chunkThreshold
+	if end > length {
+		end = length
+	}
+	return start, end
+}
+
+// buildChunkHeader builds the zkChunkHeader payload describing value once
+// split into totalChunks pieces.
+func buildChunkHeader(value []byte, totalChunks int) ([]byte, error) {
+	digest := sha256.Sum256(value)
+	return json.Marshal(zkChunkHeader{
+		Version:     zkChunkHeaderVersion,
+		TotalChunks: totalChunks,
+		SHA256:      hex.EncodeToString(digest[:]),
+	})
+}
+
+// deleteStaleChunks removes chunk znodes under fullPath with an index of
+// keep or greater, left behind when a chunked value shrinks to fewer
+// chunks than it previously had.
+func (c *ZookeeperBackend) deleteStaleChunks(fullPath string, keep int) error {
+	children, _, err := c.client.Children(fullPath)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if !strings.HasPrefix(child, chunkChildPrefix) {
+			continue
+		}
+		idx, err := strconv.Atoi(strings.TrimPrefix(child, chunkChildPrefix))
+		if err == nil && idx < keep {
+			continue
+		}
+		if err := c.deletePath(fullPath + "/" + child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func chunkPath(fullPath string, idx int) string {
+	return fmt.Sprintf("%s/%s%04d", fullPath, chunkChildPrefix, idx)
 }
 
 // Get is used to fetch an entry
 func (c *ZookeeperBackend) Get(key string) (*Entry, error) {
 	defer metrics.MeasureSince([]string{"zookeeper", "get"}, time.Now())
 
-	// Attempt to read the full path
-	fullPath := c.path + key
-	value, _, err := c.client.Get(fullPath)
+	value, _, err := c.readValue(c.dataPath + key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return &Entry{Key: key, Value: value}, nil
+}
 
-	// Ignore if the node does not exist
+// readValue fetches the raw znode at fullPath and, if it holds a chunk
+// header, reassembles and verifies the chunked value it describes. Any
+// existing leaf that doesn't parse as a chunk header is treated as a
+// plain, unchunked value for backward compatibility. Returns a nil value
+// (and nil stat) if the node doesn't exist.
+func (c *ZookeeperBackend) readValue(fullPath string) ([]byte, *zk.Stat, error) {
+	value, stat, err := c.client.Get(fullPath)
 	if err == zk.ErrNoNode {
-		err = nil
+		return nil, nil, nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if value == nil {
+		return nil, stat, nil
+	}
+
+	var header zkChunkHeader
+	if json.Unmarshal(value, &header) == nil && header.Version == zkChunkHeaderVersion && header.TotalChunks > 0 {
+		reassembled, err := c.getChunked(fullPath, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reassembled, stat, nil
+	}
+	return value, stat, nil
+}
+
+// getChunked reassembles and verifies the chunks described by header.
+func (c *ZookeeperBackend) getChunked(fullPath string, header zkChunkHeader) ([]byte, error) {
+	var buf bytes.Buffer
+	for idx := 0; idx < header.TotalChunks; idx++ {
+		chunk, _, err := c.client.Get(chunkPath(fullPath, idx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk %d of %q: %v", idx, fullPath, err)
+		}
+		buf.Write(chunk)
+	}
+
+	digest := sha256.Sum256(buf.Bytes())
+	if hex.EncodeToString(digest[:]) != header.SHA256 {
+		return nil, fmt.Errorf("chunked value at %q failed checksum verification", fullPath)
 	}
+	return buf.Bytes(), nil
+}
+
+// ErrBadVersion is returned by CheckAndSet when the version given by the
+// caller no longer matches the version currently stored in the backend.
+var ErrBadVersion = errors.New("physical: version mismatch")
+
+// TransactionalBackend is an optional interface that a physical backend may
+// implement when its underlying store can perform an atomic, version-checked
+// write. Callers that need compare-and-swap semantics (lease renewals,
+// counter increments, leader-epoch bumps) should type-assert a Backend to
+// this interface rather than assuming every backend supports it.
+type TransactionalBackend interface {
+	Backend
+
+	// GetWithVersion behaves like Get, but also returns the backend's
+	// notion of the entry's current version, for later use with
+	// CheckAndSet. A nil Entry has a version of 0.
+	GetWithVersion(key string) (*Entry, int32, error)
+
+	// CheckAndSet writes entry only if the stored version of entry.Key
+	// still matches previousVersion, returning ErrBadVersion otherwise.
+	CheckAndSet(entry *Entry, previousVersion int32) error
+}
+
+// GetWithVersion is used to fetch an entry along with the Zookeeper version
+// of its backing znode, for later use with CheckAndSet.
+func (c *ZookeeperBackend) GetWithVersion(key string) (*Entry, int32, error) {
+	defer metrics.MeasureSince([]string{"zookeeper", "get-with-version"}, time.Now())
 
-	// Handle a non-existing value
+	value, stat, err := c.readValue(c.dataPath + key)
+	if err != nil {
+		return nil, 0, err
+	}
 	if value == nil {
-		return nil, nil
+		return nil, 0, nil
 	}
-	ent := &Entry{
-		Key:   key,
-		Value: value,
+	return &Entry{Key: key, Value: value}, stat.Version, nil
+}
+
+// CheckAndSet writes entry only if the znode backing entry.Key still has
+// version previousVersion, using Zookeeper's native optimistic concurrency
+// instead of Put's unconditional overwrite (which always passes version -1).
+func (c *ZookeeperBackend) CheckAndSet(entry *Entry, previousVersion int32) error {
+	defer metrics.MeasureSince([]string{"zookeeper", "check-and-set"}, time.Now())
+
+	fullPath := c.dataPath + entry.Key
+	if len(entry.Value) > c.chunkThreshold {
+		return c.checkAndSetChunked(fullPath, entry.Value, previousVersion)
+	}
+
+	_, err := c.client.Set(fullPath, entry.Value, previousVersion)
+	switch err {
+	case zk.ErrBadVersion:
+		return ErrBadVersion
+	case zk.ErrNoNode:
+		// A previousVersion other than 0 implies the caller believed the
+		// node already existed; since it doesn't, that's a version
+		// mismatch rather than something we should silently create.
+		if previousVersion != 0 {
+			return ErrBadVersion
+		}
+		if err := c.ensurePath(fullPath, entry.Value, c.dataACL); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return err
 	}
-	return ent, nil
+
+	// A previous, larger write at this key may have left chunk children
+	// behind now that the value fits in a single znode again.
+	return c.deleteStaleChunks(fullPath, 0)
 }
 
 // Delete is used to permanently delete an entry
@@ -156,7 +864,7 @@ func (c *ZookeeperBackend) Delete(key string) error {
 	defer metrics.MeasureSince([]string{"zookeeper", "delete"}, time.Now())
 
 	// Delete the full path
-	fullPath := c.path + key
+	fullPath := c.dataPath + key
 	err := c.deletePath(fullPath)
 
 	// Mask if the node does not exist
@@ -172,7 +880,7 @@ func (c *ZookeeperBackend) List(prefix string) ([]string, error) {
 	defer metrics.MeasureSince([]string{"zookeeper", "list"}, time.Now())
 
 	// Query the children at the full path
-	fullPath := strings.TrimSuffix(c.path+prefix, "/")
+	fullPath := strings.TrimSuffix(c.dataPath+prefix, "/")
 	result, _, err := c.client.Children(fullPath)
 
 	// If the path nodes are missing, no children!
@@ -182,14 +890,22 @@ func (c *ZookeeperBackend) List(prefix string) ([]string, error) {
 
 	children := []string{}
 	for _, key := range result {
+		// Chunk znodes are an implementation detail of how large values
+		// are stored; they aren't themselves keys.
+		if strings.HasPrefix(key, chunkChildPrefix) {
+			continue
+		}
 		children = append(children, key)
 
 		// Check if this entry has any child entries,
 		// and append the slash which is what Vault depends on
 		// for iteration
 		nodeChildren, _, _ := c.client.Children(fullPath + "/" + key)
-		if nodeChildren != nil && len(nodeChildren) > 0 {
-			children = append(children, key+"/")
+		for _, nodeChild := range nodeChildren {
+			if !strings.HasPrefix(nodeChild, chunkChildPrefix) {
+				children = append(children, key+"/")
+				break
+			}
 		}
 	}
 	sort.Strings(children)
@@ -212,12 +928,24 @@ type ZookeeperHALock struct {
 	key   string
 	value string
 
-	held      bool
-	localLock sync.Mutex
-	leaderCh  chan struct{}
-	zkLock    *zk.Lock
+	held       bool
+	localLock  sync.Mutex
+	leaderCh   chan struct{}
+	leaderOnce sync.Once
+	nodePath   string
 }
 
+// lockChildPrefix is the conventional prefix, per the ZooKeeper leader
+// election recipe, for ephemeral sequential children created under a lock
+// path. The child with the lowest sequence number holds the lock.
+const lockChildPrefix = "_c_"
+
+// Lock implements the ZooKeeper leader election recipe: create an
+// ephemeral sequential child under the lock path, and if we aren't the
+// child with the lowest sequence number, watch only our immediate
+// predecessor for deletion rather than polling or watching the whole lock
+// path. This avoids the thundering herd of every waiter waking up on every
+// change in leadership.
 func (i *ZookeeperHALock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
 	i.localLock.Lock()
 	defer i.localLock.Unlock()
@@ -225,94 +953,91 @@ func (i *ZookeeperHALock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error)
 		return nil, fmt.Errorf("lock already held")
 	}
 
-	// Attempt an async acquisition
-	didLock := make(chan struct{})
-	failLock := make(chan error, 1)
-	releaseCh := make(chan bool, 1)
-	lockpath := i.in.path + i.key
-	go i.attemptLock(lockpath, didLock, failLock, releaseCh)
+	lockpath := strings.TrimSuffix(i.in.locksPath+i.key, "/")
+	if err := i.in.ensurePath(lockpath, nil, i.in.locksACL); err != nil {
+		return nil, fmt.Errorf("unable to create lock path: %v", err)
+	}
 
-	// Wait for lock acquisition, failure, or shutdown
-	select {
-	case <-didLock:
-		releaseCh <- false
-	case err := <-failLock:
-		return nil, err
-	case <-stopCh:
-		releaseCh <- true
-		return nil, nil
+	nodePath, err := i.in.client.Create(lockpath+"/"+lockChildPrefix, []byte(i.value),
+		zk.FlagEphemeral|zk.FlagSequence, i.in.locksACL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create lock node: %v", err)
+	}
+	nodeName := strings.TrimPrefix(nodePath, lockpath+"/")
+
+	for {
+		children, _, err := i.in.client.Children(lockpath)
+		if err != nil {
+			i.in.client.Delete(nodePath, -1)
+			return nil, fmt.Errorf("unable to list lock siblings: %v", err)
+		}
+		sort.Strings(children)
+
+		ownIndex := -1
+		for idx, child := range children {
+			if child == nodeName {
+				ownIndex = idx
+				break
+			}
+		}
+		if ownIndex == -1 {
+			return nil, fmt.Errorf("lock node %q disappeared while waiting to acquire", nodeName)
+		}
+		if ownIndex == 0 {
+			break
+		}
+
+		predecessor := lockpath + "/" + children[ownIndex-1]
+		exists, _, watchCh, err := i.in.client.ExistsW(predecessor)
+		if err != nil {
+			i.in.client.Delete(nodePath, -1)
+			return nil, fmt.Errorf("unable to watch predecessor lock node: %v", err)
+		}
+		if !exists {
+			// Already gone; re-list and re-check immediately.
+			continue
+		}
+
+		select {
+		case <-watchCh:
+			// Predecessor changed (most likely deleted); re-list and re-check.
+		case <-stopCh:
+			i.in.client.Delete(nodePath, -1)
+			return nil, nil
+		}
 	}
 
-	// Create the leader channel
 	i.held = true
+	i.nodePath = nodePath
 	i.leaderCh = make(chan struct{})
+	i.leaderOnce = sync.Once{}
 
-	// Watch for Events which could result in loss of our zkLock and close(i.leaderCh)
-	currentVal, _, lockeventCh, err := i.in.client.GetW(lockpath)
+	// Watch our own ephemeral node so leaderCh closes when our session is
+	// actually lost, rather than on unrelated watch events.
+	_, _, ownEventCh, err := i.in.client.ExistsW(nodePath)
 	if err != nil {
 		return nil, fmt.Errorf("unable to watch HA lock: %v", err)
 	}
-	if i.value != string(currentVal) {
-		return nil, fmt.Errorf("lost HA lock immediately before watch")
-	}
-	go i.monitorLock(lockeventCh, i.leaderCh)
+	go i.monitorLock(ownEventCh)
 
 	return i.leaderCh, nil
 }
 
-func (i *ZookeeperHALock) attemptLock(lockpath string, didLock chan struct{}, failLock chan error, releaseCh chan bool) {
-	// Wait to acquire the lock in ZK
-	acl := zk.WorldACL(zk.PermAll)
-	lock := zk.NewLock(i.in.client, lockpath, acl)
-	err := lock.Lock()
-	if err != nil {
-		failLock <- err
-		return
-	}
-	// Set node value
-	data := []byte(i.value)
-	err = i.in.ensurePath(lockpath, data)
-	if err != nil {
-		failLock <- err
-		lock.Unlock()
-		return
-	}
-	i.zkLock = lock
-
-	// Signal that lock is held
-	close(didLock)
-
-	// Handle an early abort
-	release := <-releaseCh
-	if release {
-		lock.Unlock()
+// monitorLock closes leaderCh only once the session backing our lock node
+// is genuinely gone (StateExpired/StateAuthFailed). An explicit Unlock
+// closes leaderCh itself, so a deliberate release doesn't race this watch.
+func (i *ZookeeperHALock) monitorLock(eventCh <-chan zk.Event) {
+	for event := range eventCh {
+		switch event.State {
+		case zk.StateExpired, zk.StateAuthFailed:
+			i.closeLeaderCh()
+			return
+		}
 	}
 }
 
-func (i *ZookeeperHALock) monitorLock(lockeventCh <-chan zk.Event, leaderCh chan struct{}) {
-	for {
-		select {
-		case event := <- lockeventCh:
-			// Lost connection?
-			switch event.State {
-			case zk.StateConnected:
-			case zk.StateSyncConnected:
-			case zk.StateHasSession:
-			default:
-				close(leaderCh)
-				return
-			}
-
-			// Lost lock?
-			switch event.Type {
-			case zk.EventNodeChildrenChanged:
-			case zk.EventSession:
-			default:
-				close(leaderCh)
-				return
-			}
-		}
-	}
+func (i *ZookeeperHALock) closeLeaderCh() {
+	i.leaderOnce.Do(func() { close(i.leaderCh) })
 }
 
 func (i *ZookeeperHALock) Unlock() error {
@@ -323,13 +1048,53 @@ func (i *ZookeeperHALock) Unlock() error {
 	}
 
 	i.held = false
-	i.zkLock.Unlock()
-	return nil
+	err := i.in.client.Delete(i.nodePath, -1)
+	i.closeLeaderCh()
+	return err
 }
 
 func (i *ZookeeperHALock) Value() (bool, string, error) {
-	lockpath := i.in.path + i.key
-	value, _, err := i.in.client.Get(lockpath)
-	return (value != nil), string(value), err
+	lockpath := strings.TrimSuffix(i.in.locksPath+i.key, "/")
+	children, _, err := i.in.client.Children(lockpath)
+	if err == zk.ErrNoNode {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	if len(children) == 0 {
+		return false, "", nil
+	}
+
+	sort.Strings(children)
+	value, _, err := i.in.client.Get(lockpath + "/" + children[0])
+	if err != nil {
+		return false, "", err
+	}
+	return true, string(value), nil
+}
+
+// HAStandbys returns the locked values of the current standby siblings —
+// every lock-path child other than the current leader (lowest sequence
+// number) — ordered by ascending sequence number (i.e. next-in-line
+// first). Children that can't be read, e.g. deleted mid-enumeration, are
+// silently skipped.
+func (i *ZookeeperHALock) HAStandbys() []string {
+	lockpath := strings.TrimSuffix(i.in.locksPath+i.key, "/")
+	children, _, err := i.in.client.Children(lockpath)
+	if err != nil || len(children) == 0 {
+		return nil
+	}
+	sort.Strings(children)
+
+	var standbys []string
+	for _, child := range children[1:] {
+		value, _, err := i.in.client.Get(lockpath + "/" + child)
+		if err != nil {
+			continue
+		}
+		standbys = append(standbys, string(value))
+	}
+	return standbys
 }
 
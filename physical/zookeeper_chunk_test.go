@@ -0,0 +1,128 @@
+package physical
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestChunkCount(t *testing.T) {
+	cases := []struct {
+		length, threshold, want int
+	}{
+		{0, 4, 0},
+		{1, 4, 1},
+		{4, 4, 1},
+		{5, 4, 2},
+		{8, 4, 2},
+		{9, 4, 3},
+	}
+	for _, tc := range cases {
+		if got := chunkCount(tc.length, tc.threshold); got != tc.want {
+			t.Errorf("chunkCount(%d, %d) = %d, want %d", tc.length, tc.threshold, got, tc.want)
+		}
+	}
+}
+
+func TestChunkBounds(t *testing.T) {
+	cases := []struct {
+		idx, length, threshold int
+		wantStart, wantEnd     int
+	}{
+		{0, 9, 4, 0, 4},
+		{1, 9, 4, 4, 8},
+		{2, 9, 4, 8, 9},
+		{0, 4, 4, 0, 4},
+	}
+	for _, tc := range cases {
+		start, end := chunkBounds(tc.idx, tc.length, tc.threshold)
+		if start != tc.wantStart || end != tc.wantEnd {
+			t.Errorf("chunkBounds(%d, %d, %d) = (%d, %d), want (%d, %d)",
+				tc.idx, tc.length, tc.threshold, start, end, tc.wantStart, tc.wantEnd)
+		}
+	}
+}
+
+func TestChunkPath(t *testing.T) {
+	got := chunkPath("/vault/data/secret", 3)
+	want := "/vault/data/secret/__chunk_0003"
+	if got != want {
+		t.Errorf("chunkPath() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildChunkHeader(t *testing.T) {
+	value := []byte("some value that was split into chunks")
+	raw, err := buildChunkHeader(value, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var header zkChunkHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		t.Fatalf("failed to unmarshal header: %v", err)
+	}
+
+	if header.Version != zkChunkHeaderVersion {
+		t.Errorf("header.Version = %d, want %d", header.Version, zkChunkHeaderVersion)
+	}
+	if header.TotalChunks != 3 {
+		t.Errorf("header.TotalChunks = %d, want 3", header.TotalChunks)
+	}
+
+	digest := sha256.Sum256(value)
+	if want := hex.EncodeToString(digest[:]); header.SHA256 != want {
+		t.Errorf("header.SHA256 = %q, want %q", header.SHA256, want)
+	}
+}
+
+// TestCheckAndSetChunkedOps exercises the op-building half of
+// checkAndSetChunked: the half that decides, for a CheckAndSet write over
+// the chunk threshold, which paths are staged as SetData ops versus Create
+// ops in the atomic multi that commits the header and its chunk children
+// together, and that the header's SetData is version-checked.
+func TestCheckAndSetChunkedOps(t *testing.T) {
+	value := []byte("0123456789")
+	acl := []zk.ACL{{Scheme: "world", ID: "anyone", Perms: zk.PermAll}}
+	header := []byte(`{"fake":"header"}`)
+
+	t.Run("first write creates everything", func(t *testing.T) {
+		ops := checkAndSetChunkedOps("/vault/data/secret", value, 4, 0, acl, header, false, func(int) bool { return false })
+		if len(ops) != 4 {
+			t.Fatalf("got %d ops, want 4 (1 header + 3 chunks)", len(ops))
+		}
+		headerOp, ok := ops[0].(*zk.CreateRequest)
+		if !ok || headerOp.Path != "/vault/data/secret" {
+			t.Fatalf("ops[0] = %#v, want a CreateRequest for the header path", ops[0])
+		}
+		for i, op := range ops[1:] {
+			create, ok := op.(*zk.CreateRequest)
+			if !ok || create.Path != chunkPath("/vault/data/secret", i) {
+				t.Errorf("ops[%d] = %#v, want a CreateRequest for chunk %d", i+1, op, i)
+			}
+		}
+	})
+
+	t.Run("overwrite version-checks the header and sets existing chunks", func(t *testing.T) {
+		chunkExists := func(idx int) bool { return idx < 2 }
+		ops := checkAndSetChunkedOps("/vault/data/secret", value, 4, 5, acl, header, true, chunkExists)
+
+		headerOp, ok := ops[0].(*zk.SetDataRequest)
+		if !ok || headerOp.Version != 5 {
+			t.Fatalf("ops[0] = %#v, want a SetDataRequest at version 5", ops[0])
+		}
+
+		for idx, op := range ops[1:] {
+			if chunkExists(idx) {
+				if _, ok := op.(*zk.SetDataRequest); !ok {
+					t.Errorf("ops[%d] = %#v, want a SetDataRequest for an existing chunk", idx+1, op)
+				}
+			} else if _, ok := op.(*zk.CreateRequest); !ok {
+				t.Errorf("ops[%d] = %#v, want a CreateRequest for a new chunk", idx+1, op)
+			}
+		}
+	})
+}